@@ -0,0 +1,75 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import "github.com/submariner-io/admiral/pkg/reporter"
+
+// PortSpec defines a port, and the protocol it's associated with, that needs to be opened in order for
+// Submariner to function correctly.
+type PortSpec struct {
+	Port     int64
+	Protocol string
+}
+
+// PrepareForSubmarinerInput defines the input for preparing a cloud infrastructure for Submariner.
+type PrepareForSubmarinerInput struct {
+	// InternalPorts is the set of ports that need to be opened between the gateway nodes and the rest of
+	// the cluster nodes.
+	InternalPorts []PortSpec
+
+	// SourceRanges restricts the internal ingress rules to the given CIDRs. If empty, the rules allow
+	// traffic from any source, preserving the historical behaviour.
+	SourceRanges []string
+
+	// SourceTags restricts the internal ingress rules to instances carrying one of the given network tags.
+	SourceTags []string
+
+	// TargetTags limits the internal ingress rules to the instances carrying one of the given network tags,
+	// instead of applying them to every instance in the network.
+	TargetTags []string
+}
+
+// EgressInput defines the input for preparing a cloud infrastructure's egress path for Submariner, for use in
+// VPCs that deny egress traffic by default.
+type EgressInput struct {
+	// Ports is the set of protocol/port pairs that gateway nodes need to originate traffic on.
+	Ports []PortSpec
+
+	// DestinationRanges restricts the egress rule to the given destination CIDRs. If empty, the rule allows
+	// traffic to any destination.
+	DestinationRanges []string
+}
+
+// Cloud provides an interface for a cloud provider to prepare and cleanup cloud infrastructure for Submariner.
+type Cloud interface {
+	// PrepareForSubmariner prepares the cloud for Submariner to be deployed on it.
+	PrepareForSubmariner(input PrepareForSubmarinerInput, reporter reporter.Interface) error
+
+	// CleanupAfterSubmariner cleans up any cloud settings previously configured so that Submariner can no
+	// longer be deployed on the cloud.
+	CleanupAfterSubmariner(reporter reporter.Interface) error
+
+	// PrepareEgressForSubmariner opens the egress paths gateway nodes need in clouds whose VPCs deny egress
+	// traffic by default.
+	PrepareEgressForSubmariner(input EgressInput, reporter reporter.Interface) error
+
+	// CleanupEgressAfterSubmariner cleans up any egress settings previously configured by
+	// PrepareEgressForSubmariner.
+	CleanupEgressAfterSubmariner(reporter reporter.Interface) error
+}
@@ -0,0 +1,31 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcp
+
+import "google.golang.org/api/compute/v1"
+
+//go:generate mockgen -source=./client.go -destination=./fake/client.go -package=fake
+
+// Interface wraps the subset of the GCP compute API that's needed to prepare a cloud for Submariner.
+type Interface interface {
+	GetFirewallRule(projectID, name string) (*compute.Firewall, error)
+	InsertFirewallRule(projectID string, rule *compute.Firewall) error
+	UpdateFirewallRule(projectID, name string, rule *compute.Firewall) error
+	DeleteFirewallRule(projectID, name string) error
+}
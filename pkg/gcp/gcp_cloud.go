@@ -0,0 +1,202 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcp
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/submariner-io/admiral/pkg/reporter"
+	"github.com/submariner-io/cloud-prepare/pkg/api"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// defaultSourceRange is used for the ingress rules when the caller doesn't request a restricted set of
+// source CIDRs, preserving the previous behaviour of allowing traffic from anywhere.
+const defaultSourceRange = "0.0.0.0/0"
+
+// maxPortsPerFirewallRule is GCP's limit on the number of ports/ranges that a single allowed[].ports entry
+// may contain, so protocols with more ports than this must be sharded across multiple numbered rules.
+const maxPortsPerFirewallRule = 100
+
+var submarinerProtocols = []string{"tcp", "udp"}
+
+// CloudInfo contains the information required to access the GCP APIs for a particular project in order to
+// prepare/cleanup it for Submariner.
+type CloudInfo struct {
+	InfraID   string
+	Region    string
+	ProjectID string
+	Client    Interface
+}
+
+type gcpCloud struct {
+	CloudInfo
+	reconciler FirewallReconciler
+}
+
+// Option configures optional behaviour of the api.Cloud instance returned by NewCloud.
+type Option func(*gcpCloud)
+
+// WithFirewallReconciler overrides the FirewallReconciler used to create/update/delete firewall rules,
+// instead of the default which calls the GCP Compute API directly.
+func WithFirewallReconciler(reconciler FirewallReconciler) Option {
+	return func(gc *gcpCloud) {
+		gc.reconciler = reconciler
+	}
+}
+
+// NewCloud creates a new api.Cloud instance that prepares/cleans up GCP infrastructure for Submariner.
+func NewCloud(info CloudInfo, opts ...Option) api.Cloud {
+	gc := &gcpCloud{
+		CloudInfo:  info,
+		reconciler: newComputeFirewallReconciler(info.Client, info.ProjectID),
+	}
+
+	for _, opt := range opts {
+		opt(gc)
+	}
+
+	return gc
+}
+
+func (gc *gcpCloud) PrepareForSubmariner(input api.PrepareForSubmarinerInput, reporter reporter.Interface) error {
+	for _, proto := range submarinerProtocols {
+		ports := portsForProtocol(input.InternalPorts, proto)
+		if len(ports) == 0 {
+			continue
+		}
+
+		if err := gc.reconcileIngressRule(proto, ports, input, reporter); err != nil {
+			reporter.Failed(err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (gc *gcpCloud) CleanupAfterSubmariner(reporter reporter.Interface) error {
+	for _, proto := range submarinerProtocols {
+		if err := gc.cleanupShardedRule(proto, gc.ingressRuleName, reporter); err != nil {
+			reporter.Failed(err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (gc *gcpCloud) ingressRuleName(proto string, shard int) string {
+	return fmt.Sprintf("%s-submariner-%s-ingress-%d", gc.InfraID, proto, shard)
+}
+
+func (gc *gcpCloud) reconcileIngressRule(proto string, ports []string, input api.PrepareForSubmarinerInput,
+	reporter reporter.Interface,
+) error {
+	return gc.reconcileShardedRule(proto, ports, gc.ingressRuleName,
+		func(name, proto string, ports []string) *compute.Firewall {
+			return gc.newIngressRule(name, proto, ports, input)
+		}, reporter)
+}
+
+func (gc *gcpCloud) newIngressRule(name, proto string, ports []string, input api.PrepareForSubmarinerInput) *compute.Firewall {
+	return &compute.Firewall{
+		Name:         name,
+		Direction:    "INGRESS",
+		SourceRanges: sourceRangesOrDefault(input.SourceRanges),
+		SourceTags:   input.SourceTags,
+		TargetTags:   input.TargetTags,
+		Allowed: []*compute.FirewallAllowed{
+			{
+				IPProtocol: strings.ToUpper(proto),
+				Ports:      ports,
+			},
+		},
+	}
+}
+
+// shardPorts splits ports into consecutive groups of at most size entries each, preserving order.
+func shardPorts(ports []string, size int) [][]string {
+	var shards [][]string
+
+	for len(ports) > 0 {
+		end := size
+		if end > len(ports) {
+			end = len(ports)
+		}
+
+		shards = append(shards, ports[:end])
+		ports = ports[end:]
+	}
+
+	return shards
+}
+
+func portsForProtocol(specs []api.PortSpec, proto string) []string {
+	var ports []string
+
+	for _, spec := range specs {
+		if strings.EqualFold(spec.Protocol, proto) {
+			ports = append(ports, strconv.FormatInt(spec.Port, 10))
+		}
+	}
+
+	sort.Strings(ports)
+
+	return ports
+}
+
+func sourceRangesOrDefault(ranges []string) []string {
+	if len(ranges) == 0 {
+		return []string{defaultSourceRange}
+	}
+
+	return ranges
+}
+
+func stringSlicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	sortedA := append([]string(nil), a...)
+	sortedB := append([]string(nil), b...)
+
+	sort.Strings(sortedA)
+	sort.Strings(sortedB)
+
+	for i := range sortedA {
+		if sortedA[i] != sortedB[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isNotFoundError(err error) bool {
+	gerr, ok := err.(*googleapi.Error) //nolint:errorlint // googleapi errors aren't typically wrapped.
+
+	return ok && gerr.Code == http.StatusNotFound
+}
@@ -0,0 +1,53 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcp_test
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/cloud-prepare/pkg/gcp/fake"
+)
+
+const (
+	infraID   = "test-infraID"
+	region    = "east-us"
+	projectID = "test-project"
+)
+
+func TestGCP(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "GCP Cloud Suite")
+}
+
+type fakeGCPClientBase struct {
+	mockCtrl  *gomock.Controller
+	gcpClient *fake.MockInterface
+}
+
+func (t *fakeGCPClientBase) beforeEach() {
+	t.mockCtrl = gomock.NewController(GinkgoT())
+	t.gcpClient = fake.NewMockInterface(t.mockCtrl)
+}
+
+func (t *fakeGCPClientBase) afterEach() {
+	t.mockCtrl.Finish()
+}
@@ -20,6 +20,7 @@ package gcp_test
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/golang/mock/gomock"
@@ -32,7 +33,14 @@ import (
 	"google.golang.org/api/googleapi"
 )
 
-const ingressRuleName = "test-infraID-submariner-internal-ports-ingress"
+const (
+	tcpIngressRuleName = "test-infraID-submariner-tcp-ingress-0"
+	udpIngressRuleName = "test-infraID-submariner-udp-ingress-0"
+)
+
+func shardRuleName(proto string, shard int) string {
+	return fmt.Sprintf("%s-submariner-%s-ingress-%d", infraID, proto, shard)
+}
 
 var _ = Describe("Cloud", func() {
 	Describe("PrepareForSubmariner", testPrepareForSubmariner)
@@ -42,10 +50,13 @@ var _ = Describe("Cloud", func() {
 func testPrepareForSubmariner() {
 	t := newCloudTestDriver()
 
-	var retError error
+	var (
+		input    api.PrepareForSubmarinerInput
+		retError error
+	)
 
-	JustBeforeEach(func() {
-		retError = t.cloud.PrepareForSubmariner(api.PrepareForSubmarinerInput{
+	BeforeEach(func() {
+		input = api.PrepareForSubmarinerInput{
 			InternalPorts: []api.PortSpec{
 				{
 					Port:     100,
@@ -56,29 +67,42 @@ func testPrepareForSubmariner() {
 					Protocol: "UDP",
 				},
 			},
-		}, reporter.Stdout())
+		}
+	})
+
+	JustBeforeEach(func() {
+		retError = t.cloud.PrepareForSubmariner(input, reporter.Stdout())
 	})
 
-	When("the firewall rule doesn't exist", func() {
+	When("neither firewall rule exists", func() {
 		BeforeEach(func() {
-			t.gcpClient.EXPECT().GetFirewallRule(projectID, ingressRuleName).Return(nil, &googleapi.Error{Code: http.StatusNotFound})
+			// Some child contexts exit before every protocol/shard is processed, so these tolerate being
+			// called fewer times than the full happy path would call them.
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, tcpIngressRuleName).Return(nil, &googleapi.Error{Code: http.StatusNotFound}).AnyTimes()
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, udpIngressRuleName).Return(nil, &googleapi.Error{Code: http.StatusNotFound}).AnyTimes()
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, shardRuleName("tcp", 1)).Return(nil, &googleapi.Error{Code: http.StatusNotFound}).AnyTimes()
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, shardRuleName("udp", 1)).Return(nil, &googleapi.Error{Code: http.StatusNotFound}).AnyTimes()
 		})
 
 		Context("", func() {
-			var actualRule *compute.Firewall
+			var actualTCPRule, actualUDPRule *compute.Firewall
 
 			BeforeEach(func() {
 				t.gcpClient.EXPECT().InsertFirewallRule(projectID, gomock.Any()).DoAndReturn(func(_ string, rule *compute.Firewall) error {
-					actualRule = rule
+					actualTCPRule = rule
+					return nil
+				})
+				t.gcpClient.EXPECT().InsertFirewallRule(projectID, gomock.Any()).DoAndReturn(func(_ string, rule *compute.Firewall) error {
+					actualUDPRule = rule
 					return nil
 				})
 			})
 
-			It("should correctly insert it", func() {
+			It("should correctly insert one rule per protocol, open to any source", func() {
 				Expect(retError).To(Succeed())
 
-				Expect(actualRule).ToNot(BeNil(), "InsertFirewallRule was not called")
-				assertIngressRule(actualRule)
+				assertAllowedRule(findRuleByName(tcpIngressRuleName, actualTCPRule, actualUDPRule), tcpIngressRuleName, "TCP", "100")
+				assertAllowedRule(findRuleByName(udpIngressRuleName, actualTCPRule, actualUDPRule), udpIngressRuleName, "UDP", "200")
 			})
 		})
 
@@ -91,54 +115,271 @@ func testPrepareForSubmariner() {
 				Expect(retError).ToNot(Succeed())
 			})
 		})
+
+		Context("and insertion fails with a forbidden error", func() {
+			BeforeEach(func() {
+				t.gcpClient.EXPECT().InsertFirewallRule(projectID, gomock.Any()).Return(&googleapi.Error{Code: http.StatusForbidden}).AnyTimes()
+			})
+
+			It("should return an error", func() {
+				// There's no existing rule for the requested ports to possibly already be open on, so this
+				// must not be degraded away.
+				Expect(retError).ToNot(Succeed())
+			})
+		})
 	})
 
-	When("the firewall rule already exists", func() {
+	When("SourceRanges, SourceTags and TargetTags are requested", func() {
+		var captured []*compute.Firewall
+
 		BeforeEach(func() {
-			t.gcpClient.EXPECT().GetFirewallRule(projectID, ingressRuleName).DoAndReturn(func(_, ruleName string) (*compute.Firewall, error) {
-				return &compute.Firewall{Name: ruleName}, nil
+			input.SourceRanges = []string{"10.0.0.0/8"}
+			input.SourceTags = []string{"submariner-gw"}
+			input.TargetTags = []string{"submariner-gw"}
+			captured = nil
+
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, tcpIngressRuleName).Return(nil, &googleapi.Error{Code: http.StatusNotFound})
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, udpIngressRuleName).Return(nil, &googleapi.Error{Code: http.StatusNotFound})
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, shardRuleName("tcp", 1)).Return(nil, &googleapi.Error{Code: http.StatusNotFound})
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, shardRuleName("udp", 1)).Return(nil, &googleapi.Error{Code: http.StatusNotFound})
+			t.gcpClient.EXPECT().InsertFirewallRule(projectID, gomock.Any()).Times(2).DoAndReturn(func(_ string, rule *compute.Firewall) error {
+				captured = append(captured, rule)
+				return nil
 			})
 		})
 
+		It("should propagate them onto the created rules", func() {
+			Expect(retError).To(Succeed())
+			Expect(captured).To(HaveLen(2))
+
+			for _, rule := range captured {
+				Expect(rule.SourceRanges).To(Equal([]string{"10.0.0.0/8"}))
+				Expect(rule.SourceTags).To(Equal([]string{"submariner-gw"}))
+				Expect(rule.TargetTags).To(Equal([]string{"submariner-gw"}))
+			}
+		})
+	})
+
+	When("the firewall rules already exist and match the desired state", func() {
+		BeforeEach(func() {
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, tcpIngressRuleName).DoAndReturn(func(_, name string) (*compute.Firewall, error) {
+				return &compute.Firewall{
+					Name:         name,
+					SourceRanges: []string{"0.0.0.0/0"},
+					Allowed: []*compute.FirewallAllowed{
+						{IPProtocol: "TCP", Ports: []string{"100"}},
+					},
+				}, nil
+			})
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, udpIngressRuleName).DoAndReturn(func(_, name string) (*compute.Firewall, error) {
+				return &compute.Firewall{
+					Name:         name,
+					SourceRanges: []string{"0.0.0.0/0"},
+					Allowed: []*compute.FirewallAllowed{
+						{IPProtocol: "UDP", Ports: []string{"200"}},
+					},
+				}, nil
+			})
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, shardRuleName("tcp", 1)).Return(nil, &googleapi.Error{Code: http.StatusNotFound})
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, shardRuleName("udp", 1)).Return(nil, &googleapi.Error{Code: http.StatusNotFound})
+		})
+
+		It("should not update either rule", func() {
+			Expect(retError).To(Succeed())
+		})
+	})
+
+	When("the SourceRanges of an existing rule have drifted", func() {
+		BeforeEach(func() {
+			input.SourceRanges = []string{"10.0.0.0/8"}
+
+			// Some child contexts exit before the UDP protocol is ever reconciled, so these tolerate being
+			// called fewer times than the full happy path would call them.
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, tcpIngressRuleName).DoAndReturn(func(_, name string) (*compute.Firewall, error) {
+				return &compute.Firewall{
+					Name:         name,
+					SourceRanges: []string{"0.0.0.0/0"},
+					Allowed: []*compute.FirewallAllowed{
+						{IPProtocol: "TCP", Ports: []string{"100"}},
+					},
+				}, nil
+			}).AnyTimes()
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, udpIngressRuleName).DoAndReturn(func(_, name string) (*compute.Firewall, error) {
+				return &compute.Firewall{
+					Name:         name,
+					SourceRanges: []string{"10.0.0.0/8"},
+					Allowed: []*compute.FirewallAllowed{
+						{IPProtocol: "UDP", Ports: []string{"200"}},
+					},
+				}, nil
+			}).AnyTimes()
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, shardRuleName("tcp", 1)).Return(nil, &googleapi.Error{Code: http.StatusNotFound}).AnyTimes()
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, shardRuleName("udp", 1)).Return(nil, &googleapi.Error{Code: http.StatusNotFound}).AnyTimes()
+		})
+
 		Context("", func() {
 			var actualRule *compute.Firewall
 
 			BeforeEach(func() {
-				t.gcpClient.EXPECT().UpdateFirewallRule(projectID, ingressRuleName, gomock.Any()).DoAndReturn(
+				t.gcpClient.EXPECT().UpdateFirewallRule(projectID, tcpIngressRuleName, gomock.Any()).DoAndReturn(
 					func(_, _ string, rule *compute.Firewall) error {
 						actualRule = rule
 						return nil
 					})
 			})
 
-			It("should update it", func() {
+			It("should update only the drifted rule", func() {
 				Expect(retError).To(Succeed())
 
-				Expect(actualRule).ToNot(BeNil(), "UpdateFirewallRule was not called")
-				assertIngressRule(actualRule)
+				Expect(actualRule).ToNot(BeNil(), "UpdateFirewallRule was not called for the TCP rule")
+				Expect(actualRule.SourceRanges).To(Equal([]string{"10.0.0.0/8"}))
 			})
 		})
 
-		Context("and update fails", func() {
+		Context("and the update fails", func() {
 			BeforeEach(func() {
-				t.gcpClient.EXPECT().UpdateFirewallRule(projectID, ingressRuleName, gomock.Any()).Return(errors.New("fake update error"))
+				t.gcpClient.EXPECT().UpdateFirewallRule(projectID, tcpIngressRuleName, gomock.Any()).Return(errors.New("fake update error"))
 			})
 
 			It("should return an error", func() {
 				Expect(retError).ToNot(Succeed())
 			})
 		})
+
+		Context("and the update fails with a forbidden error", func() {
+			BeforeEach(func() {
+				t.gcpClient.EXPECT().UpdateFirewallRule(projectID, tcpIngressRuleName, gomock.Any()).
+					Return(&googleapi.Error{Code: http.StatusForbidden})
+			})
+
+			It("should not return an error", func() {
+				// The requested ports are already open on the existing rule; only SourceRanges drifted, so
+				// this is safe to degrade gracefully.
+				Expect(retError).To(Succeed())
+			})
+		})
 	})
 
-	When("retrieval of the firewall rule fails", func() {
+	When("the internal ports of an existing rule have drifted and the update is forbidden", func() {
 		BeforeEach(func() {
-			t.gcpClient.EXPECT().GetFirewallRule(projectID, ingressRuleName).Return(nil, errors.New("fake get error"))
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, tcpIngressRuleName).Return(&compute.Firewall{
+				Name:         tcpIngressRuleName,
+				SourceRanges: []string{"0.0.0.0/0"},
+				Allowed: []*compute.FirewallAllowed{
+					{IPProtocol: "TCP", Ports: []string{"999"}},
+				},
+			}, nil)
+			t.gcpClient.EXPECT().UpdateFirewallRule(projectID, tcpIngressRuleName, gomock.Any()).
+				Return(&googleapi.Error{Code: http.StatusForbidden})
 		})
 
 		It("should return an error", func() {
+			// The requested ports are still missing on the existing rule, so this must not be degraded away.
 			Expect(retError).ToNot(Succeed())
 		})
 	})
+
+	When("retrieval of a firewall rule fails", func() {
+		BeforeEach(func() {
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, tcpIngressRuleName).Return(nil, errors.New("fake get error"))
+		})
+
+		It("should return an error", func() {
+			Expect(retError).ToNot(Succeed())
+		})
+	})
+
+	When("the internal ports for a protocol exceed the per-rule limit", func() {
+		var insertedRules []*compute.Firewall
+
+		BeforeEach(func() {
+			insertedRules = nil
+
+			input.InternalPorts = nil
+			for port := 0; port < 250; port++ {
+				input.InternalPorts = append(input.InternalPorts, api.PortSpec{Port: int64(1000 + port), Protocol: "TCP"})
+			}
+
+			for shard := 0; shard <= 3; shard++ {
+				t.gcpClient.EXPECT().GetFirewallRule(projectID, shardRuleName("tcp", shard)).Return(nil, &googleapi.Error{Code: http.StatusNotFound})
+			}
+
+			t.gcpClient.EXPECT().InsertFirewallRule(projectID, gomock.Any()).Times(3).DoAndReturn(func(_ string, rule *compute.Firewall) error {
+				insertedRules = append(insertedRules, rule)
+				return nil
+			})
+		})
+
+		It("should shard the ports across three numbered rules", func() {
+			Expect(retError).To(Succeed())
+			Expect(insertedRules).To(HaveLen(3))
+
+			byName := map[string]*compute.Firewall{}
+			for _, rule := range insertedRules {
+				byName[rule.Name] = rule
+			}
+
+			Expect(byName[shardRuleName("tcp", 0)].Allowed[0].Ports).To(HaveLen(100))
+			Expect(byName[shardRuleName("tcp", 1)].Allowed[0].Ports).To(HaveLen(100))
+			Expect(byName[shardRuleName("tcp", 2)].Allowed[0].Ports).To(HaveLen(50))
+		})
+	})
+
+	When("the number of required shards shrinks", func() {
+		BeforeEach(func() {
+			input.InternalPorts = nil
+			for port := 0; port < 50; port++ {
+				input.InternalPorts = append(input.InternalPorts, api.PortSpec{Port: int64(1000 + port), Protocol: "TCP"})
+			}
+
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, shardRuleName("tcp", 0)).DoAndReturn(func(_, name string) (*compute.Firewall, error) {
+				return &compute.Firewall{
+					Name:         name,
+					SourceRanges: []string{"0.0.0.0/0"},
+					Allowed: []*compute.FirewallAllowed{
+						{IPProtocol: "TCP", Ports: []string{"1000"}},
+					},
+				}, nil
+			})
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, shardRuleName("tcp", 1)).Return(&compute.Firewall{Name: shardRuleName("tcp", 1)}, nil)
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, shardRuleName("tcp", 2)).Return(nil, &googleapi.Error{Code: http.StatusNotFound})
+
+			t.gcpClient.EXPECT().UpdateFirewallRule(projectID, shardRuleName("tcp", 0), gomock.Any()).Return(nil)
+			t.gcpClient.EXPECT().DeleteFirewallRule(projectID, shardRuleName("tcp", 1)).Return(nil)
+		})
+
+		It("should delete the now-orphaned shard", func() {
+			Expect(retError).To(Succeed())
+		})
+	})
+
+	When("the number of required shards shrinks and deleting the orphaned shard is forbidden", func() {
+		BeforeEach(func() {
+			input.InternalPorts = nil
+			for port := 0; port < 50; port++ {
+				input.InternalPorts = append(input.InternalPorts, api.PortSpec{Port: int64(1000 + port), Protocol: "TCP"})
+			}
+
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, shardRuleName("tcp", 0)).DoAndReturn(func(_, name string) (*compute.Firewall, error) {
+				return &compute.Firewall{
+					Name:         name,
+					SourceRanges: []string{"0.0.0.0/0"},
+					Allowed: []*compute.FirewallAllowed{
+						{IPProtocol: "TCP", Ports: []string{"1000"}},
+					},
+				}, nil
+			})
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, shardRuleName("tcp", 1)).Return(&compute.Firewall{Name: shardRuleName("tcp", 1)}, nil)
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, shardRuleName("tcp", 2)).Return(nil, &googleapi.Error{Code: http.StatusNotFound})
+
+			t.gcpClient.EXPECT().UpdateFirewallRule(projectID, shardRuleName("tcp", 0), gomock.Any()).Return(nil)
+			t.gcpClient.EXPECT().DeleteFirewallRule(projectID, shardRuleName("tcp", 1)).Return(&googleapi.Error{Code: http.StatusForbidden})
+		})
+
+		It("should warn rather than fail, leaving the orphaned shard in place", func() {
+			Expect(retError).To(Succeed())
+		})
+	})
 }
 
 func testCleanupAfterSubmariner() {
@@ -152,17 +393,21 @@ func testCleanupAfterSubmariner() {
 
 	Context("on success", func() {
 		BeforeEach(func() {
-			t.gcpClient.EXPECT().DeleteFirewallRule(projectID, ingressRuleName).Return(nil)
+			t.gcpClient.EXPECT().DeleteFirewallRule(projectID, tcpIngressRuleName).Return(nil)
+			t.gcpClient.EXPECT().DeleteFirewallRule(projectID, shardRuleName("tcp", 1)).Return(&googleapi.Error{Code: http.StatusNotFound})
+			t.gcpClient.EXPECT().DeleteFirewallRule(projectID, udpIngressRuleName).Return(nil)
+			t.gcpClient.EXPECT().DeleteFirewallRule(projectID, shardRuleName("udp", 1)).Return(&googleapi.Error{Code: http.StatusNotFound})
 		})
 
-		It("should delete the firewall rule", func() {
+		It("should delete every shard of both firewall rules", func() {
 			Expect(retError).To(Succeed())
 		})
 	})
 
-	When("the firewall rule doesn't exist", func() {
+	When("a firewall rule doesn't exist", func() {
 		BeforeEach(func() {
-			t.gcpClient.EXPECT().DeleteFirewallRule(projectID, ingressRuleName).Return(&googleapi.Error{Code: http.StatusNotFound})
+			t.gcpClient.EXPECT().DeleteFirewallRule(projectID, tcpIngressRuleName).Return(&googleapi.Error{Code: http.StatusNotFound})
+			t.gcpClient.EXPECT().DeleteFirewallRule(projectID, udpIngressRuleName).Return(&googleapi.Error{Code: http.StatusNotFound})
 		})
 
 		It("should succeed", func() {
@@ -172,13 +417,42 @@ func testCleanupAfterSubmariner() {
 
 	When("deletion fails", func() {
 		BeforeEach(func() {
-			t.gcpClient.EXPECT().DeleteFirewallRule(projectID, ingressRuleName).Return(errors.New("fake delete error"))
+			t.gcpClient.EXPECT().DeleteFirewallRule(projectID, tcpIngressRuleName).Return(errors.New("fake delete error"))
 		})
 
 		It("should return an error", func() {
 			Expect(retError).ToNot(Succeed())
 		})
 	})
+
+	When("deletion fails with a forbidden error", func() {
+		BeforeEach(func() {
+			// No shard-1 expectation for tcp: a forbidden delete stops the probe for that protocol right
+			// away instead of continuing on to the next numbered shard.
+			t.gcpClient.EXPECT().DeleteFirewallRule(projectID, tcpIngressRuleName).Return(&googleapi.Error{Code: http.StatusForbidden})
+			t.gcpClient.EXPECT().DeleteFirewallRule(projectID, udpIngressRuleName).Return(nil)
+			t.gcpClient.EXPECT().DeleteFirewallRule(projectID, shardRuleName("udp", 1)).Return(&googleapi.Error{Code: http.StatusNotFound})
+		})
+
+		It("should not return an error", func() {
+			Expect(retError).To(Succeed())
+		})
+	})
+
+	When("the service account isn't permitted to delete firewall rules at all", func() {
+		BeforeEach(func() {
+			// Every shard name probed comes back forbidden, as it would for a service account that lacks
+			// compute.firewalls.delete entirely. Only one DeleteFirewallRule call per protocol is expected:
+			// if the shard scan isn't bounded, gomock will fail this test on the next, unmocked call instead
+			// of the suite hanging.
+			t.gcpClient.EXPECT().DeleteFirewallRule(projectID, tcpIngressRuleName).Return(&googleapi.Error{Code: http.StatusForbidden})
+			t.gcpClient.EXPECT().DeleteFirewallRule(projectID, udpIngressRuleName).Return(&googleapi.Error{Code: http.StatusForbidden})
+		})
+
+		It("should warn once per protocol and return without an error", func() {
+			Expect(retError).To(Succeed())
+		})
+	})
 }
 
 type cloudTestDriver struct {
@@ -205,16 +479,23 @@ func newCloudTestDriver() *cloudTestDriver {
 	return t
 }
 
-func assertIngressRule(rule *compute.Firewall) {
-	Expect(rule.Name).To(Equal(ingressRuleName))
+func findRuleByName(name string, rules ...*compute.Firewall) *compute.Firewall {
+	for _, rule := range rules {
+		if rule != nil && rule.Name == name {
+			return rule
+		}
+	}
+
+	return nil
+}
+
+func assertAllowedRule(rule *compute.Firewall, name, protocol, port string) {
+	Expect(rule).ToNot(BeNil(), "no rule named %q was created", name)
 	Expect(rule.Direction).To(Equal("INGRESS"))
-	Expect(rule.Allowed).To(HaveLen(2))
+	Expect(rule.SourceRanges).To(Equal([]string{"0.0.0.0/0"}))
+	Expect(rule.Allowed).To(HaveLen(1))
 	Expect(rule.Allowed[0]).To(Equal(&compute.FirewallAllowed{
-		IPProtocol: "TCP",
-		Ports:      []string{"100"},
-	}))
-	Expect(rule.Allowed[1]).To(Equal(&compute.FirewallAllowed{
-		IPProtocol: "UDP",
-		Ports:      []string{"200"},
+		IPProtocol: protocol,
+		Ports:      []string{port},
 	}))
 }
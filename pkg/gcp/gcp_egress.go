@@ -0,0 +1,92 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/submariner-io/admiral/pkg/reporter"
+	"github.com/submariner-io/cloud-prepare/pkg/api"
+	"google.golang.org/api/compute/v1"
+)
+
+// defaultDestinationRange is used for the egress rules when the caller doesn't request a restricted set of
+// destination CIDRs, allowing traffic to anywhere.
+const defaultDestinationRange = "0.0.0.0/0"
+
+func (gc *gcpCloud) PrepareEgressForSubmariner(input api.EgressInput, reporter reporter.Interface) error {
+	for _, proto := range submarinerProtocols {
+		ports := portsForProtocol(input.Ports, proto)
+		if len(ports) == 0 {
+			continue
+		}
+
+		if err := gc.reconcileEgressRule(proto, ports, input, reporter); err != nil {
+			reporter.Failed(err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (gc *gcpCloud) CleanupEgressAfterSubmariner(reporter reporter.Interface) error {
+	for _, proto := range submarinerProtocols {
+		if err := gc.cleanupShardedRule(proto, gc.egressRuleName, reporter); err != nil {
+			reporter.Failed(err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (gc *gcpCloud) egressRuleName(proto string, shard int) string {
+	return fmt.Sprintf("%s-submariner-%s-egress-%d", gc.InfraID, proto, shard)
+}
+
+func (gc *gcpCloud) reconcileEgressRule(proto string, ports []string, input api.EgressInput, reporter reporter.Interface) error {
+	return gc.reconcileShardedRule(proto, ports, gc.egressRuleName,
+		func(name, proto string, ports []string) *compute.Firewall {
+			return gc.newEgressRule(name, proto, ports, input)
+		}, reporter)
+}
+
+func (gc *gcpCloud) newEgressRule(name, proto string, ports []string, input api.EgressInput) *compute.Firewall {
+	return &compute.Firewall{
+		Name:              name,
+		Direction:         "EGRESS",
+		DestinationRanges: destinationRangesOrDefault(input.DestinationRanges),
+		Allowed: []*compute.FirewallAllowed{
+			{
+				IPProtocol: strings.ToUpper(proto),
+				Ports:      ports,
+			},
+		},
+	}
+}
+
+func destinationRangesOrDefault(ranges []string) []string {
+	if len(ranges) == 0 {
+		return []string{defaultDestinationRange}
+	}
+
+	return ranges
+}
@@ -0,0 +1,308 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcp_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/admiral/pkg/reporter"
+	"github.com/submariner-io/cloud-prepare/pkg/api"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	tcpEgressRuleName = "test-infraID-submariner-tcp-egress-0"
+	udpEgressRuleName = "test-infraID-submariner-udp-egress-0"
+)
+
+func shardEgressRuleName(proto string, shard int) string {
+	return fmt.Sprintf("%s-submariner-%s-egress-%d", infraID, proto, shard)
+}
+
+var _ = Describe("Cloud", func() {
+	Describe("PrepareEgressForSubmariner", testPrepareEgressForSubmariner)
+	Describe("CleanupEgressAfterSubmariner", testCleanupEgressAfterSubmariner)
+})
+
+func testPrepareEgressForSubmariner() {
+	t := newCloudTestDriver()
+
+	var (
+		input    api.EgressInput
+		retError error
+	)
+
+	BeforeEach(func() {
+		input = api.EgressInput{
+			Ports: []api.PortSpec{
+				{
+					Port:     100,
+					Protocol: "TCP",
+				},
+				{
+					Port:     200,
+					Protocol: "UDP",
+				},
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		retError = t.cloud.PrepareEgressForSubmariner(input, reporter.Stdout())
+	})
+
+	When("neither firewall rule exists", func() {
+		BeforeEach(func() {
+			// Some child contexts exit before every protocol/shard is processed, so these tolerate being
+			// called fewer times than the full happy path would call them.
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, tcpEgressRuleName).Return(nil, &googleapi.Error{Code: http.StatusNotFound}).AnyTimes()
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, udpEgressRuleName).Return(nil, &googleapi.Error{Code: http.StatusNotFound}).AnyTimes()
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, shardEgressRuleName("tcp", 1)).Return(nil, &googleapi.Error{Code: http.StatusNotFound}).AnyTimes()
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, shardEgressRuleName("udp", 1)).Return(nil, &googleapi.Error{Code: http.StatusNotFound}).AnyTimes()
+		})
+
+		Context("", func() {
+			var actualTCPRule, actualUDPRule *compute.Firewall
+
+			BeforeEach(func() {
+				t.gcpClient.EXPECT().InsertFirewallRule(projectID, gomock.Any()).DoAndReturn(func(_ string, rule *compute.Firewall) error {
+					actualTCPRule = rule
+					return nil
+				})
+				t.gcpClient.EXPECT().InsertFirewallRule(projectID, gomock.Any()).DoAndReturn(func(_ string, rule *compute.Firewall) error {
+					actualUDPRule = rule
+					return nil
+				})
+			})
+
+			It("should correctly insert one rule per protocol, open to any destination", func() {
+				Expect(retError).To(Succeed())
+
+				assertEgressRule(findRuleByName(tcpEgressRuleName, actualTCPRule, actualUDPRule), tcpEgressRuleName, "TCP", "100")
+				assertEgressRule(findRuleByName(udpEgressRuleName, actualTCPRule, actualUDPRule), udpEgressRuleName, "UDP", "200")
+			})
+		})
+
+		Context("and insertion fails", func() {
+			BeforeEach(func() {
+				t.gcpClient.EXPECT().InsertFirewallRule(projectID, gomock.Any()).Return(errors.New("fake insert error"))
+			})
+
+			It("should return an error", func() {
+				Expect(retError).ToNot(Succeed())
+			})
+		})
+
+		Context("and insertion fails with a forbidden error", func() {
+			BeforeEach(func() {
+				t.gcpClient.EXPECT().InsertFirewallRule(projectID, gomock.Any()).Return(&googleapi.Error{Code: http.StatusForbidden}).AnyTimes()
+			})
+
+			It("should return an error", func() {
+				// There's no existing rule for the requested ports to possibly already be open on, so this
+				// must not be degraded away.
+				Expect(retError).ToNot(Succeed())
+			})
+		})
+	})
+
+	When("the firewall rules already exist and match the desired state", func() {
+		BeforeEach(func() {
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, tcpEgressRuleName).DoAndReturn(func(_, name string) (*compute.Firewall, error) {
+				return &compute.Firewall{
+					Name:              name,
+					DestinationRanges: []string{"0.0.0.0/0"},
+					Allowed: []*compute.FirewallAllowed{
+						{IPProtocol: "TCP", Ports: []string{"100"}},
+					},
+				}, nil
+			})
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, udpEgressRuleName).DoAndReturn(func(_, name string) (*compute.Firewall, error) {
+				return &compute.Firewall{
+					Name:              name,
+					DestinationRanges: []string{"0.0.0.0/0"},
+					Allowed: []*compute.FirewallAllowed{
+						{IPProtocol: "UDP", Ports: []string{"200"}},
+					},
+				}, nil
+			})
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, shardEgressRuleName("tcp", 1)).Return(nil, &googleapi.Error{Code: http.StatusNotFound})
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, shardEgressRuleName("udp", 1)).Return(nil, &googleapi.Error{Code: http.StatusNotFound})
+		})
+
+		It("should not update either rule", func() {
+			Expect(retError).To(Succeed())
+		})
+	})
+
+	When("retrieval of a firewall rule fails", func() {
+		BeforeEach(func() {
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, tcpEgressRuleName).Return(nil, errors.New("fake get error"))
+		})
+
+		It("should return an error", func() {
+			Expect(retError).ToNot(Succeed())
+		})
+	})
+
+	When("the ports of an existing rule are missing and the update is forbidden", func() {
+		BeforeEach(func() {
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, tcpEgressRuleName).Return(&compute.Firewall{
+				Name:              tcpEgressRuleName,
+				DestinationRanges: []string{"0.0.0.0/0"},
+				Allowed: []*compute.FirewallAllowed{
+					{IPProtocol: "TCP", Ports: []string{"999"}},
+				},
+			}, nil)
+			t.gcpClient.EXPECT().UpdateFirewallRule(projectID, tcpEgressRuleName, gomock.Any()).
+				Return(&googleapi.Error{Code: http.StatusForbidden})
+		})
+
+		It("should return an error", func() {
+			// The requested port is still missing on the existing rule, so this must not be degraded away.
+			Expect(retError).ToNot(Succeed())
+		})
+	})
+
+	When("the ports for a protocol exceed the per-rule limit", func() {
+		var insertedRules []*compute.Firewall
+
+		BeforeEach(func() {
+			insertedRules = nil
+
+			input.Ports = nil
+			for port := 0; port < 250; port++ {
+				input.Ports = append(input.Ports, api.PortSpec{Port: int64(1000 + port), Protocol: "TCP"})
+			}
+
+			for shard := 0; shard <= 3; shard++ {
+				t.gcpClient.EXPECT().GetFirewallRule(projectID, shardEgressRuleName("tcp", shard)).Return(nil, &googleapi.Error{Code: http.StatusNotFound})
+			}
+
+			t.gcpClient.EXPECT().InsertFirewallRule(projectID, gomock.Any()).Times(3).DoAndReturn(func(_ string, rule *compute.Firewall) error {
+				insertedRules = append(insertedRules, rule)
+				return nil
+			})
+		})
+
+		It("should shard the ports across three numbered rules", func() {
+			Expect(retError).To(Succeed())
+			Expect(insertedRules).To(HaveLen(3))
+
+			byName := map[string]*compute.Firewall{}
+			for _, rule := range insertedRules {
+				byName[rule.Name] = rule
+			}
+
+			Expect(byName[shardEgressRuleName("tcp", 0)].Allowed[0].Ports).To(HaveLen(100))
+			Expect(byName[shardEgressRuleName("tcp", 1)].Allowed[0].Ports).To(HaveLen(100))
+			Expect(byName[shardEgressRuleName("tcp", 2)].Allowed[0].Ports).To(HaveLen(50))
+		})
+	})
+}
+
+func testCleanupEgressAfterSubmariner() {
+	t := newCloudTestDriver()
+
+	var retError error
+
+	JustBeforeEach(func() {
+		retError = t.cloud.CleanupEgressAfterSubmariner(reporter.Stdout())
+	})
+
+	Context("on success", func() {
+		BeforeEach(func() {
+			t.gcpClient.EXPECT().DeleteFirewallRule(projectID, tcpEgressRuleName).Return(nil)
+			t.gcpClient.EXPECT().DeleteFirewallRule(projectID, shardEgressRuleName("tcp", 1)).Return(&googleapi.Error{Code: http.StatusNotFound})
+			t.gcpClient.EXPECT().DeleteFirewallRule(projectID, udpEgressRuleName).Return(nil)
+			t.gcpClient.EXPECT().DeleteFirewallRule(projectID, shardEgressRuleName("udp", 1)).Return(&googleapi.Error{Code: http.StatusNotFound})
+		})
+
+		It("should delete every shard of both firewall rules", func() {
+			Expect(retError).To(Succeed())
+		})
+	})
+
+	When("a firewall rule doesn't exist", func() {
+		BeforeEach(func() {
+			t.gcpClient.EXPECT().DeleteFirewallRule(projectID, tcpEgressRuleName).Return(&googleapi.Error{Code: http.StatusNotFound})
+			t.gcpClient.EXPECT().DeleteFirewallRule(projectID, udpEgressRuleName).Return(&googleapi.Error{Code: http.StatusNotFound})
+		})
+
+		It("should succeed", func() {
+			Expect(retError).To(Succeed())
+		})
+	})
+
+	When("deletion fails", func() {
+		BeforeEach(func() {
+			t.gcpClient.EXPECT().DeleteFirewallRule(projectID, tcpEgressRuleName).Return(errors.New("fake delete error"))
+		})
+
+		It("should return an error", func() {
+			Expect(retError).ToNot(Succeed())
+		})
+	})
+
+	When("deletion fails with a forbidden error", func() {
+		BeforeEach(func() {
+			// No shard-1 expectation for tcp: a forbidden delete stops the probe for that protocol right
+			// away instead of continuing on to the next numbered shard.
+			t.gcpClient.EXPECT().DeleteFirewallRule(projectID, tcpEgressRuleName).Return(&googleapi.Error{Code: http.StatusForbidden})
+			t.gcpClient.EXPECT().DeleteFirewallRule(projectID, udpEgressRuleName).Return(nil)
+			t.gcpClient.EXPECT().DeleteFirewallRule(projectID, shardEgressRuleName("udp", 1)).Return(&googleapi.Error{Code: http.StatusNotFound})
+		})
+
+		It("should not return an error", func() {
+			Expect(retError).To(Succeed())
+		})
+	})
+
+	When("the service account isn't permitted to delete firewall rules at all", func() {
+		BeforeEach(func() {
+			// Every shard name probed comes back forbidden, as it would for a service account that lacks
+			// compute.firewalls.delete entirely. Only one DeleteFirewallRule call per protocol is expected:
+			// if the shard scan isn't bounded, gomock will fail this test on the next, unmocked call instead
+			// of the suite hanging.
+			t.gcpClient.EXPECT().DeleteFirewallRule(projectID, tcpEgressRuleName).Return(&googleapi.Error{Code: http.StatusForbidden})
+			t.gcpClient.EXPECT().DeleteFirewallRule(projectID, udpEgressRuleName).Return(&googleapi.Error{Code: http.StatusForbidden})
+		})
+
+		It("should warn once per protocol and return without an error", func() {
+			Expect(retError).To(Succeed())
+		})
+	})
+}
+
+func assertEgressRule(rule *compute.Firewall, name, protocol, port string) {
+	Expect(rule).ToNot(BeNil(), "no rule named %q was created", name)
+	Expect(rule.Direction).To(Equal("EGRESS"))
+	Expect(rule.DestinationRanges).To(Equal([]string{"0.0.0.0/0"}))
+	Expect(rule.Allowed).To(HaveLen(1))
+	Expect(rule.Allowed[0]).To(Equal(&compute.FirewallAllowed{
+		IPProtocol: protocol,
+		Ports:      []string{port},
+	}))
+}
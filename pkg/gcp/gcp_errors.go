@@ -0,0 +1,60 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcp
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	permissionCreateFirewall = "compute.firewalls.create"
+	permissionUpdateFirewall = "compute.firewalls.update"
+	permissionDeleteFirewall = "compute.firewalls.delete"
+
+	firewallAdminRole = "roles/compute.securityAdmin"
+)
+
+// ErrInsufficientFirewallPermissions indicates that the configured GCP service account lacks one or more of
+// the IAM permissions needed to mutate firewall rules, e.g. because the firewalls are provisioned and owned by
+// a separate admin team.
+type ErrInsufficientFirewallPermissions struct {
+	// Permissions is the set of IAM permissions (e.g. "compute.firewalls.create") that were denied.
+	Permissions []string
+}
+
+func (e *ErrInsufficientFirewallPermissions) Error() string {
+	return fmt.Sprintf("the GCP service account is missing the %s IAM permission(s); grant %s (or an equivalent "+
+		"custom role) to the service account, or pre-provision the Submariner firewall rules out-of-band",
+		strings.Join(e.Permissions, ", "), firewallAdminRole)
+}
+
+// asFirewallPermissionsError converts err to an *ErrInsufficientFirewallPermissions carrying the given missing
+// permission if err is a 403/Forbidden googleapi.Error, returning nil otherwise.
+func asFirewallPermissionsError(err error, permission string) *ErrInsufficientFirewallPermissions {
+	gerr, ok := err.(*googleapi.Error) //nolint:errorlint // googleapi errors aren't typically wrapped.
+	if !ok || gerr.Code != http.StatusForbidden {
+		return nil
+	}
+
+	return &ErrInsufficientFirewallPermissions{Permissions: []string{permission}}
+}
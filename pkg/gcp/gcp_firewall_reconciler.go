@@ -0,0 +1,226 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcp
+
+import (
+	"github.com/pkg/errors"
+	"github.com/submariner-io/admiral/pkg/reporter"
+	"google.golang.org/api/compute/v1"
+)
+
+// FirewallReconciler abstracts how a desired firewall rule is reconciled against a GCP project. This lets
+// PrepareForSubmariner/PrepareEgressForSubmariner target the live Compute API, render a plan without mutating
+// anything, or merely verify rules that are provisioned out-of-band (e.g. by Terraform or Config Connector),
+// without duplicating the reconcile/diff logic for each mode.
+type FirewallReconciler interface {
+	// Reconcile ensures a firewall rule matching desired exists.
+	Reconcile(desired *compute.Firewall, reporter reporter.Interface) error
+
+	// Delete removes the named firewall rule, reporting whether it was found. A caller probing numbered
+	// shards should treat a non-nil err as a reason to stop, not just existed=false: a denied err doesn't
+	// mean the shard was absent, only that its state couldn't be determined.
+	Delete(name string, reporter reporter.Interface) (existed bool, err error)
+
+	// Exists reports whether a firewall rule with the given name is currently present, without deleting it.
+	Exists(name string) (bool, error)
+}
+
+// computeFirewallReconciler reconciles firewall rules directly against the GCP Compute API. This is the
+// default reconciler used by NewCloud.
+type computeFirewallReconciler struct {
+	client    Interface
+	projectID string
+}
+
+func newComputeFirewallReconciler(client Interface, projectID string) FirewallReconciler {
+	return &computeFirewallReconciler{client: client, projectID: projectID}
+}
+
+func (r *computeFirewallReconciler) Reconcile(desired *compute.Firewall, reporter reporter.Interface) error {
+	existing, err := r.client.GetFirewallRule(r.projectID, desired.Name)
+
+	if isNotFoundError(err) {
+		if err := r.client.InsertFirewallRule(r.projectID, desired); err != nil {
+			// There's no existing rule at all here, so the requested ports can't possibly be open yet -
+			// degrading gracefully would silently leave Submariner broken, so always surface the error.
+			if permErr := asFirewallPermissionsError(err, permissionCreateFirewall); permErr != nil {
+				return permErr
+			}
+
+			return errors.Wrapf(err, "error creating firewall rule %q", desired.Name)
+		}
+
+		reporter.Succeeded("Created firewall rule %q on GCP", desired.Name)
+
+		return nil
+	} else if err != nil {
+		return errors.Wrapf(err, "error retrieving firewall rule %q", desired.Name)
+	}
+
+	if !firewallRuleNeedsUpdate(existing, desired) {
+		return nil
+	}
+
+	if err := r.client.UpdateFirewallRule(r.projectID, desired.Name, desired); err != nil {
+		if permErr := asFirewallPermissionsError(err, permissionUpdateFirewall); permErr != nil {
+			// Only degrade gracefully if the ports we actually care about are already open; other drift
+			// (e.g. source ranges/tags) we couldn't apply is worth a warning, but it doesn't leave Submariner broken.
+			if portsSatisfied(existing, desired) {
+				reporter.Warning("%s", permErr.Error())
+				return nil
+			}
+
+			return permErr
+		}
+
+		return errors.Wrapf(err, "error updating firewall rule %q", desired.Name)
+	}
+
+	reporter.Succeeded("Updated firewall rule %q on GCP", desired.Name)
+
+	return nil
+}
+
+func (r *computeFirewallReconciler) Delete(name string, reporter reporter.Interface) (bool, error) {
+	err := r.client.DeleteFirewallRule(r.projectID, name)
+	if isNotFoundError(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		// Surface permission errors to the caller rather than masking them as existed=true: this is a
+		// mutating call denied by IAM before GCP even checks whether the rule exists, so there's no actual
+		// "existed" signal to report here, and a caller probing numbered shards needs to know to stop rather
+		// than keep treating every subsequent shard name as present too.
+		if permErr := asFirewallPermissionsError(err, permissionDeleteFirewall); permErr != nil {
+			return false, permErr
+		}
+
+		return false, errors.Wrapf(err, "error deleting firewall rule %q", name)
+	}
+
+	reporter.Succeeded("Removed firewall rule %q on GCP", name)
+
+	return true, nil
+}
+
+func (r *computeFirewallReconciler) Exists(name string) (bool, error) {
+	_, err := r.client.GetFirewallRule(r.projectID, name)
+	if isNotFoundError(err) {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Wrapf(err, "error retrieving firewall rule %q", name)
+	}
+
+	return true, nil
+}
+
+// planFirewallReconciler reports each desired firewall rule via the reporter without mutating GCP, for
+// operators who want to review changes (e.g. via Terraform/Config Connector) before they're applied. It
+// never reads the rules currently in GCP, so what it reports is not a diff against the existing state.
+type planFirewallReconciler struct{}
+
+// NewPlanFirewallReconciler returns a FirewallReconciler that never mutates GCP, only reporting what it
+// would have done.
+func NewPlanFirewallReconciler() FirewallReconciler {
+	return &planFirewallReconciler{}
+}
+
+func (r *planFirewallReconciler) Reconcile(desired *compute.Firewall, reporter reporter.Interface) error {
+	reporter.Succeeded("[plan] would ensure firewall rule %q (direction=%s, allowed=%v, sourceRanges=%v, "+
+		"sourceTags=%v, targetTags=%v, destinationRanges=%v)", desired.Name, desired.Direction, desired.Allowed,
+		desired.SourceRanges, desired.SourceTags, desired.TargetTags, desired.DestinationRanges)
+
+	return nil
+}
+
+// Delete always reports existed=false since a plan never tracks real state, so callers that probe
+// shard-by-shard (e.g. CleanupAfterSubmariner) stop after the first rule instead of looping forever.
+func (r *planFirewallReconciler) Delete(name string, reporter reporter.Interface) (bool, error) {
+	reporter.Succeeded("[plan] would delete firewall rule %q", name)
+	return false, nil
+}
+
+// Exists always reports false since a plan never tracks real state, so callers that use it to decide
+// whether there's anything left to clean up (e.g. deleteOrphanShards) find nothing to report.
+func (r *planFirewallReconciler) Exists(_ string) (bool, error) {
+	return false, nil
+}
+
+// externalFirewallReconciler verifies that firewall rules provisioned out-of-band (e.g. by Terraform or
+// Config Connector) already match the expected shape, failing fast if they don't. It never mutates GCP.
+type externalFirewallReconciler struct {
+	client    Interface
+	projectID string
+}
+
+// NewExternalFirewallReconciler returns a FirewallReconciler for users whose firewalls are managed outside
+// of cloud-prepare; it only ever reads, never writes.
+func NewExternalFirewallReconciler(client Interface, projectID string) FirewallReconciler {
+	return &externalFirewallReconciler{client: client, projectID: projectID}
+}
+
+func (r *externalFirewallReconciler) Reconcile(desired *compute.Firewall, reporter reporter.Interface) error {
+	existing, err := r.client.GetFirewallRule(r.projectID, desired.Name)
+	if isNotFoundError(err) {
+		return errors.Errorf("externally managed firewall rule %q was expected to already exist but doesn't", desired.Name)
+	} else if err != nil {
+		return errors.Wrapf(err, "error retrieving externally managed firewall rule %q", desired.Name)
+	}
+
+	if firewallRuleNeedsUpdate(existing, desired) {
+		return errors.Errorf("externally managed firewall rule %q doesn't match the expected shape", desired.Name)
+	}
+
+	reporter.Succeeded("Verified externally managed firewall rule %q on GCP", desired.Name)
+
+	return nil
+}
+
+// Delete never actually removes the externally managed rule, and always reports existed=false so that
+// callers which probe shard-by-shard (e.g. CleanupAfterSubmariner) stop immediately instead of looping
+// forever over a rule this reconciler will never make disappear.
+func (r *externalFirewallReconciler) Delete(name string, reporter reporter.Interface) (bool, error) {
+	reporter.Succeeded("Skipping deletion of externally managed firewall rule %q", name)
+	return false, nil
+}
+
+// Exists always reports false: cloud-prepare never deletes externally managed rules, so there's nothing
+// for deleteOrphanShards to find and clean up, regardless of what's actually provisioned.
+func (r *externalFirewallReconciler) Exists(_ string) (bool, error) {
+	return false, nil
+}
+
+// portsSatisfied reports whether the existing rule's ports already match what was requested, regardless of
+// any other drift (source/destination ranges or tags) that couldn't be applied.
+func portsSatisfied(existing, desired *compute.Firewall) bool {
+	return len(existing.Allowed) > 0 && stringSlicesEqual(existing.Allowed[0].Ports, desired.Allowed[0].Ports)
+}
+
+func firewallRuleNeedsUpdate(existing, desired *compute.Firewall) bool {
+	if len(existing.Allowed) == 0 {
+		return true
+	}
+
+	return !stringSlicesEqual(existing.Allowed[0].Ports, desired.Allowed[0].Ports) ||
+		!stringSlicesEqual(existing.SourceRanges, desired.SourceRanges) ||
+		!stringSlicesEqual(existing.SourceTags, desired.SourceTags) ||
+		!stringSlicesEqual(existing.TargetTags, desired.TargetTags) ||
+		!stringSlicesEqual(existing.DestinationRanges, desired.DestinationRanges)
+}
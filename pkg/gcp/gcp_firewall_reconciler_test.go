@@ -0,0 +1,206 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcp_test
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/admiral/pkg/reporter"
+	"github.com/submariner-io/cloud-prepare/pkg/api"
+	"github.com/submariner-io/cloud-prepare/pkg/gcp"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+const ruleName = "test-infraID-submariner-tcp-ingress-0"
+
+var _ = Describe("PlanFirewallReconciler", func() {
+	var reconciler gcp.FirewallReconciler
+
+	BeforeEach(func() {
+		reconciler = gcp.NewPlanFirewallReconciler()
+	})
+
+	Specify("Reconcile should succeed without mutating GCP", func() {
+		Expect(reconciler.Reconcile(&compute.Firewall{Name: ruleName}, reporter.Stdout())).To(Succeed())
+	})
+
+	Specify("Delete should succeed without mutating GCP and report that the rule didn't exist", func() {
+		existed, err := reconciler.Delete(ruleName, reporter.Stdout())
+		Expect(err).To(Succeed())
+		Expect(existed).To(BeFalse())
+	})
+
+	Specify("Exists should always report false", func() {
+		exists, err := reconciler.Exists(ruleName)
+		Expect(err).To(Succeed())
+		Expect(exists).To(BeFalse())
+	})
+})
+
+var _ = Describe("ExternalFirewallReconciler", func() {
+	t := fakeGCPClientBase{}
+	var reconciler gcp.FirewallReconciler
+
+	BeforeEach(func() {
+		t.beforeEach()
+		reconciler = gcp.NewExternalFirewallReconciler(t.gcpClient, projectID)
+	})
+
+	AfterEach(t.afterEach)
+
+	Describe("Reconcile", func() {
+		desired := &compute.Firewall{
+			Name:    ruleName,
+			Allowed: []*compute.FirewallAllowed{{IPProtocol: "TCP", Ports: []string{"100"}}},
+		}
+
+		When("the externally managed rule matches the desired state", func() {
+			It("should succeed", func() {
+				t.gcpClient.EXPECT().GetFirewallRule(projectID, ruleName).Return(desired, nil)
+
+				Expect(reconciler.Reconcile(desired, reporter.Stdout())).To(Succeed())
+			})
+		})
+
+		When("the externally managed rule doesn't match the desired state", func() {
+			It("should return an error", func() {
+				t.gcpClient.EXPECT().GetFirewallRule(projectID, ruleName).Return(&compute.Firewall{
+					Name:    ruleName,
+					Allowed: []*compute.FirewallAllowed{{IPProtocol: "TCP", Ports: []string{"200"}}},
+				}, nil)
+
+				Expect(reconciler.Reconcile(desired, reporter.Stdout())).ToNot(Succeed())
+			})
+		})
+
+		When("the externally managed rule doesn't exist", func() {
+			It("should return an error", func() {
+				t.gcpClient.EXPECT().GetFirewallRule(projectID, ruleName).Return(nil, &googleapi.Error{Code: http.StatusNotFound})
+
+				Expect(reconciler.Reconcile(desired, reporter.Stdout())).ToNot(Succeed())
+			})
+		})
+
+		When("retrieval of the externally managed rule fails", func() {
+			It("should return an error", func() {
+				t.gcpClient.EXPECT().GetFirewallRule(projectID, ruleName).Return(nil, errors.New("fake get error"))
+
+				Expect(reconciler.Reconcile(desired, reporter.Stdout())).ToNot(Succeed())
+			})
+		})
+	})
+
+	Describe("Delete", func() {
+		It("should succeed without deleting the externally managed rule and report that it didn't exist", func() {
+			existed, err := reconciler.Delete(ruleName, reporter.Stdout())
+			Expect(err).To(Succeed())
+			Expect(existed).To(BeFalse())
+		})
+	})
+
+	Describe("Exists", func() {
+		It("should always report false", func() {
+			exists, err := reconciler.Exists(ruleName)
+			Expect(err).To(Succeed())
+			Expect(exists).To(BeFalse())
+		})
+	})
+})
+
+// These specs wire PrepareForSubmariner/CleanupAfterSubmariner to each FirewallReconciler
+// mode in turn via WithFirewallReconciler, verifying the same caller-facing contract holds regardless of which
+// mode is configured.
+var _ = Describe("Cloud with each FirewallReconciler mode", func() {
+	t := fakeGCPClientBase{}
+
+	BeforeEach(t.beforeEach)
+	AfterEach(t.afterEach)
+
+	input := api.PrepareForSubmarinerInput{
+		InternalPorts: []api.PortSpec{{Port: 100, Protocol: "TCP"}},
+	}
+
+	makeCloud := func(opts ...gcp.Option) api.Cloud {
+		return gcp.NewCloud(gcp.CloudInfo{
+			InfraID:   infraID,
+			Region:    region,
+			ProjectID: projectID,
+			Client:    t.gcpClient,
+		}, opts...)
+	}
+
+	When("using the default compute reconciler", func() {
+		It("should create the firewall rule and delete it on cleanup", func() {
+			cloud := makeCloud()
+
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, tcpIngressRuleName).Return(nil, &googleapi.Error{Code: http.StatusNotFound})
+			t.gcpClient.EXPECT().InsertFirewallRule(projectID, gomock.Any()).Return(nil)
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, shardRuleName("tcp", 1)).Return(nil, &googleapi.Error{Code: http.StatusNotFound})
+
+			Expect(cloud.PrepareForSubmariner(input, reporter.Stdout())).To(Succeed())
+
+			t.gcpClient.EXPECT().DeleteFirewallRule(projectID, tcpIngressRuleName).Return(nil)
+			t.gcpClient.EXPECT().DeleteFirewallRule(projectID, shardRuleName("tcp", 1)).Return(&googleapi.Error{Code: http.StatusNotFound})
+			t.gcpClient.EXPECT().DeleteFirewallRule(projectID, udpIngressRuleName).Return(&googleapi.Error{Code: http.StatusNotFound})
+
+			Expect(cloud.CleanupAfterSubmariner(reporter.Stdout())).To(Succeed())
+		})
+	})
+
+	When("using the plan reconciler", func() {
+		It("should succeed for both PrepareForSubmariner and CleanupAfterSubmariner without mutating GCP", func() {
+			cloud := makeCloud(gcp.WithFirewallReconciler(gcp.NewPlanFirewallReconciler()))
+
+			Expect(cloud.PrepareForSubmariner(input, reporter.Stdout())).To(Succeed())
+			Expect(cloud.CleanupAfterSubmariner(reporter.Stdout())).To(Succeed())
+		})
+	})
+
+	When("using the external reconciler and the rule already matches", func() {
+		It("should succeed for PrepareForSubmariner and leave the rule untouched on cleanup", func() {
+			cloud := makeCloud(gcp.WithFirewallReconciler(gcp.NewExternalFirewallReconciler(t.gcpClient, projectID)))
+
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, tcpIngressRuleName).Return(&compute.Firewall{
+				Name:         tcpIngressRuleName,
+				SourceRanges: []string{"0.0.0.0/0"},
+				Allowed: []*compute.FirewallAllowed{
+					{IPProtocol: "TCP", Ports: []string{"100"}},
+				},
+			}, nil)
+
+			Expect(cloud.PrepareForSubmariner(input, reporter.Stdout())).To(Succeed())
+			Expect(cloud.CleanupAfterSubmariner(reporter.Stdout())).To(Succeed())
+		})
+	})
+
+	When("using the external reconciler and the rule is missing", func() {
+		It("should return an error from PrepareForSubmariner", func() {
+			cloud := makeCloud(gcp.WithFirewallReconciler(gcp.NewExternalFirewallReconciler(t.gcpClient, projectID)))
+
+			t.gcpClient.EXPECT().GetFirewallRule(projectID, tcpIngressRuleName).Return(nil, &googleapi.Error{Code: http.StatusNotFound})
+
+			Expect(cloud.PrepareForSubmariner(input, reporter.Stdout())).ToNot(Succeed())
+		})
+	})
+})
@@ -0,0 +1,111 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcp
+
+import (
+	"github.com/submariner-io/admiral/pkg/reporter"
+	"google.golang.org/api/compute/v1"
+)
+
+// shardedRuleNamer returns the name of the numbered shard of a protocol's firewall rule. Ingress and egress
+// each have their own naming scheme, but share the sharding/orphan-cleanup logic below.
+type shardedRuleNamer func(proto string, shard int) string
+
+// shardedRuleBuilder builds the desired firewall rule for one shard's worth of ports.
+type shardedRuleBuilder func(name, proto string, ports []string) *compute.Firewall
+
+// reconcileShardedRule splits ports into shards of at most maxPortsPerFirewallRule, reconciles each shard in
+// turn, then prunes any shards left over from a previous reconcile that needed more of them than this one
+// does, e.g. because the number of ports shrank.
+func (gc *gcpCloud) reconcileShardedRule(proto string, ports []string, namer shardedRuleNamer, build shardedRuleBuilder,
+	reporter reporter.Interface,
+) error {
+	shards := shardPorts(ports, maxPortsPerFirewallRule)
+
+	for shard, shardPorts := range shards {
+		desired := build(namer(proto, shard), proto, shardPorts)
+
+		if err := gc.reconciler.Reconcile(desired, reporter); err != nil {
+			return err
+		}
+	}
+
+	return gc.deleteOrphanShards(proto, len(shards), namer, reporter)
+}
+
+// deleteOrphanShards removes any numbered shards left over from a previous reconcile that needed more of them
+// than this one does, e.g. because the number of ports shrank. If the configured GCP service account is denied
+// permission to delete an orphan shard, it warns and carries on with the next shard instead of failing: leaving
+// a now-unneeded orphan rule in place doesn't affect the rules this reconcile actually needs, so it's not worth
+// failing the whole Prepare call over. It returns once a shard is reported as not existing.
+func (gc *gcpCloud) deleteOrphanShards(proto string, shardsInUse int, namer shardedRuleNamer, reporter reporter.Interface) error {
+	for shard := shardsInUse; ; shard++ {
+		ruleName := namer(proto, shard)
+
+		exists, err := gc.reconciler.Exists(ruleName)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			return nil
+		}
+
+		if _, err := gc.reconciler.Delete(ruleName, reporter); err != nil && !warnIfFirewallPermissionsError(err, reporter) {
+			return err
+		}
+	}
+}
+
+// cleanupShardedRule deletes every numbered shard of a protocol's firewall rule, stopping once a shard is
+// reported as not having existed. It also stops, without failing, if the configured GCP service account is
+// denied permission to delete firewall rules at all: that denial is returned for every shard name probed,
+// including ones that were never created, so trusting it to eventually report existed=false would spin
+// forever instead of warning once and returning.
+func (gc *gcpCloud) cleanupShardedRule(proto string, namer shardedRuleNamer, reporter reporter.Interface) error {
+	for shard := 0; ; shard++ {
+		ruleName := namer(proto, shard)
+
+		existed, err := gc.reconciler.Delete(ruleName, reporter)
+		if err != nil {
+			if warnIfFirewallPermissionsError(err, reporter) {
+				return nil
+			}
+
+			return err
+		}
+
+		if !existed {
+			return nil
+		}
+	}
+}
+
+// warnIfFirewallPermissionsError reports err via reporter.Warning and returns true if it's a denied firewall
+// delete, so callers can treat it as a non-fatal condition instead of failing outright.
+func warnIfFirewallPermissionsError(err error, reporter reporter.Interface) bool {
+	permErr, ok := err.(*ErrInsufficientFirewallPermissions) //nolint:errorlint // returned directly, never wrapped.
+	if !ok {
+		return false
+	}
+
+	reporter.Warning("%s", permErr.Error())
+
+	return true
+}